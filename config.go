@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/Scrin/screeps-exporter/pkg/screeps"
+)
+
+// Account describes a single Screeps account to scrape. Official servers are
+// authenticated with a pre-issued API token; private servers don't issue
+// those, so Username/Password are exchanged for a token via /api/auth/signin
+// instead.
+type Account struct {
+	Name     string   `yaml:"name"`
+	BaseURL  string   `yaml:"base_url"`
+	Token    string   `yaml:"token"`
+	Username string   `yaml:"username"`
+	Password string   `yaml:"password"`
+	Shards   []string `yaml:"shards"`
+	Segments []int    `yaml:"segments"`
+	Realtime bool     `yaml:"realtime"`
+}
+
+// ParseSegments parses a comma-separated segment list such as "0,1,5" into
+// the segment IDs to fetch and merge.
+func ParseSegments(s string) ([]int, error) {
+	parts := strings.Split(s, ",")
+	segments := make([]int, 0, len(parts))
+	for _, part := range parts {
+		segment, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil {
+			return nil, fmt.Errorf("invalid segment %q: %w", part, err)
+		}
+		segments = append(segments, segment)
+	}
+	return segments, nil
+}
+
+// Config is the top-level YAML document listing every account the exporter
+// should scrape.
+type Config struct {
+	Accounts []Account `yaml:"accounts"`
+}
+
+// LoadConfig reads and parses a multi-account config file, filling in the
+// official Screeps base URL and the default memory segment where they were
+// left unset. Every account's Name must be set and unique, since it becomes
+// the "account" label on every metric the exporter produces.
+func LoadConfig(path string) (Config, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Config{}, err
+	}
+	var config Config
+	if err := yaml.Unmarshal(raw, &config); err != nil {
+		return Config{}, err
+	}
+	seen := make(map[string]bool, len(config.Accounts))
+	for i := range config.Accounts {
+		if config.Accounts[i].Name == "" {
+			return Config{}, fmt.Errorf("account %d: name is required", i)
+		}
+		if seen[config.Accounts[i].Name] {
+			return Config{}, fmt.Errorf("account %q: duplicate name", config.Accounts[i].Name)
+		}
+		seen[config.Accounts[i].Name] = true
+		if config.Accounts[i].BaseURL == "" {
+			config.Accounts[i].BaseURL = "https://screeps.com"
+		}
+		if len(config.Accounts[i].Segments) == 0 {
+			config.Accounts[i].Segments = []int{0}
+		}
+	}
+	return config, nil
+}
+
+// resolveToken returns the account's API token, signing in against its
+// private server first if no token was configured directly.
+func resolveToken(account Account) (string, error) {
+	if account.Token != "" {
+		return account.Token, nil
+	}
+	if account.Username != "" {
+		return screeps.NewClient(account.BaseURL, "").SignIn(context.Background(), account.Username, account.Password)
+	}
+	return "", fmt.Errorf("account %q has neither a token nor username/password", account.Name)
+}
@@ -1,368 +1,541 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"regexp"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/push"
+	"golang.org/x/sync/singleflight"
+
+	"github.com/Scrin/screeps-exporter/pkg/screeps"
 )
 
-type AuthMeResponse struct {
-	Money     float64            `json:"money"`
-	CPUShard  map[string]float64 `json:"cpuShard"`
-	Resources map[string]float64 `json:"resources"`
-}
+const prefix = "screeps_"
+
+// nonAlphanumeric matches anything not valid in a Prometheus metric name,
+// used to sanitize stat names that come from user-controlled console log
+// lines before they're used as the "stat" label value of the log-stat counter.
+var nonAlphanumeric = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// ttlCache coalesces concurrent refreshes of a single upstream value behind a
+// singleflight call and serves the last good value for up to ttl, so that
+// several Prometheus servers scraping in parallel don't each hit the Screeps
+// API, and a scrape never blocks on more than one in-flight fetch.
+type ttlCache struct {
+	ttl time.Duration
+
+	mu        sync.RWMutex
+	value     interface{}
+	fetchedAt time.Time
 
-type MarketOrder struct {
-	Active          bool    `json:"active"`
-	Type            string  `json:"type"`
-	Amount          float64 `json:"amount"`
-	RemainingAmount float64 `json:"remainingAmount"`
-	ResourceType    string  `json:"resourceType"`
-	Price           float64 `json:"price"`
-	TotalAmount     float64 `json:"totalAmount"`
-	RoomName        string  `json:"roomName"`
+	sf singleflight.Group
 }
 
-type MarketOrdersResponse struct {
-	Shards map[string][]MarketOrder `json:"shards"`
+func newTTLCache(ttl time.Duration) *ttlCache {
+	return &ttlCache{ttl: ttl}
 }
 
-type MemoryResponse struct {
-	Ok   float64 `json:"ok"`
-	Data string  `json:"data"`
+func (c *ttlCache) Get(fetch func() (interface{}, error)) (interface{}, error) {
+	c.mu.RLock()
+	if !c.fetchedAt.IsZero() && time.Since(c.fetchedAt) < c.ttl {
+		v := c.value
+		c.mu.RUnlock()
+		return v, nil
+	}
+	c.mu.RUnlock()
+
+	v, err, _ := c.sf.Do("fetch", func() (interface{}, error) {
+		val, err := fetch()
+		if err != nil {
+			return nil, err
+		}
+		c.mu.Lock()
+		c.value = val
+		c.fetchedAt = time.Now()
+		c.mu.Unlock()
+		return val, nil
+	})
+	return v, err
 }
 
-type Cpu struct {
-	Used   float64 `json:"used"`
-	Limit  float64 `json:"limit"`
-	Bucket float64 `json:"bucket"`
+// accountState holds one account's scrape configuration together with its
+// own per-endpoint caches, so that a failing account never invalidates the
+// cache of another account, and an in-flight fetch for one account never
+// coalesces with another's. Collect still scrapes accounts one after
+// another, so a slow account delays the accounts after it.
+type accountState struct {
+	name     string
+	client   *screeps.Client
+	shards   []string
+	segments []int
+
+	authMeCache       *ttlCache
+	marketOrdersCache *ttlCache
+	statsCache        *ttlCache
+
+	segmentFetchersMu sync.Mutex
+	segmentFetchers   map[string]*segmentFetcher
+
+	realtime bool
+
+	// liveCPU holds the most recent CPU reading pushed over the realtime
+	// websocket subscription, if any, so scrapes can reflect the current
+	// tick instead of waiting for the next poll.
+	liveCPUMu sync.Mutex
+	liveCPU   *screeps.Cpu
+	liveCPUAt time.Time
 }
 
-type Progress struct {
-	Level         float64 `json:"level"`
-	Progress      float64 `json:"progress"`
-	ProgressTotal float64 `json:"progressTotal"`
+// recordLiveCPU stores the latest CPU reading pushed over the websocket
+// "cpu" channel.
+func (a *accountState) recordLiveCPU(cpu screeps.Cpu) {
+	a.liveCPUMu.Lock()
+	defer a.liveCPUMu.Unlock()
+	a.liveCPU = &cpu
+	a.liveCPUAt = time.Now()
 }
 
-type Room struct {
-	RCL                     Progress           `json:"rcl"`
-	Structures              map[string]float64 `json:"structures"`
-	Creeps                  float64            `json:"creeps"`
-	EnergyAvailable         float64            `json:"energyAvailable"`
-	EnergyCapacityAvailable float64            `json:"energyCapacityAvailable"`
-	Storage                 map[string]float64 `json:"storage"`
-	Terminal                map[string]float64 `json:"terminal"`
+// liveCPUMaxAge bounds how long a pushed CPU reading is trusted before
+// overlayLiveCPU falls back to the polled value, so a dead or stalled
+// websocket doesn't pin CPU usage at a stale reading forever.
+const liveCPUMaxAge = 30 * time.Second
+
+// overlayLiveCPU replaces stats.CPU.Used with the live websocket reading when
+// one has been pushed within liveCPUMaxAge, leaving Limit and Bucket (which
+// the "cpu" channel doesn't carry) at their polled values.
+func (a *accountState) overlayLiveCPU(stats screeps.Stats) screeps.Stats {
+	a.liveCPUMu.Lock()
+	defer a.liveCPUMu.Unlock()
+	if a.liveCPU != nil && time.Since(a.liveCPUAt) < liveCPUMaxAge {
+		stats.CPU.Used = a.liveCPU.Used
+	}
+	return stats
 }
 
-type Stats struct {
-	Tick                float64         `json:"tick"`
-	Ms                  float64         `json:"ms"`
-	LastGlobalResetTick float64         `json:"lastGlobalResetTick"`
-	LastGlobalResetMs   float64         `json:"lastGlobalResetMs"`
-	CPU                 Cpu             `json:"cpu"`
-	GCL                 Progress        `json:"gcl"`
-	GPL                 Progress        `json:"gpl"`
-	Rooms               map[string]Room `json:"rooms"`
+// fetcherForShard returns the segmentFetcher tracking batching/caching state
+// for a given shard, creating it on first use.
+func (a *accountState) fetcherForShard(shard string) *segmentFetcher {
+	a.segmentFetchersMu.Lock()
+	defer a.segmentFetchersMu.Unlock()
+	if a.segmentFetchers == nil {
+		a.segmentFetchers = make(map[string]*segmentFetcher)
+	}
+	f, ok := a.segmentFetchers[shard]
+	if !ok {
+		f = &segmentFetcher{}
+		a.segmentFetchers[shard] = f
+	}
+	return f
 }
 
-const prefix = "screeps_"
+// ScreepsCollector implements prometheus.Collector, fetching from the Screeps
+// API (or serving from a short-lived per-endpoint cache) synchronously on
+// every /metrics scrape, instead of maintaining long-lived GaugeVecs that get
+// Reset() on a fixed tick. This avoids ghost series for rooms, shards and
+// market orders that disappear between scrapes, and the race between a
+// background Reset() and an in-flight promhttp.Handler read. It scrapes one
+// or more accounts, each tagged with its own "account" label.
+type ScreepsCollector struct {
+	accounts []*accountState
+
+	cpuShardDesc     *prometheus.Desc
+	resourcesDesc    *prometheus.Desc
+	marketOrdersDesc *prometheus.Desc
+	tickDesc         *prometheus.Desc
+	msDesc           *prometheus.Desc
+	resetTickDesc    *prometheus.Desc
+	resetMsDesc      *prometheus.Desc
+	cpuDesc          *prometheus.Desc
+	gclDesc          *prometheus.Desc
+	gplDesc          *prometheus.Desc
+	rclDesc          *prometheus.Desc
+	energyDesc       *prometheus.Desc
+	creepsDesc       *prometheus.Desc
+	structuresDesc   *prometheus.Desc
+	storageDesc      *prometheus.Desc
+	terminalDesc     *prometheus.Desc
+
+	scrapeDuration *prometheus.HistogramVec
+	scrapeRequests *prometheus.CounterVec
+
+	// logStatCounter counts structured stat lines seen on the realtime
+	// console subscription. Its label schema is fixed (unlike the stat
+	// lines themselves, which carry arbitrary user-controlled fields) so
+	// that an untrusted console line can never register a conflicting
+	// label set for an existing metric name.
+	logStatCounter *prometheus.CounterVec
+}
 
-var (
-	shards  []string
-	segment = "0"
-	token   = ""
-
-	client = &http.Client{}
-
-	knownRooms map[string]prometheus.Labels
-
-	cpuShard           *prometheus.GaugeVec
-	resources          *prometheus.GaugeVec
-	marketOrders       *prometheus.GaugeVec
-	tick               *prometheus.GaugeVec
-	ms                 *prometheus.GaugeVec
-	resetTick          *prometheus.GaugeVec
-	resetMs            *prometheus.GaugeVec
-	cpu                *prometheus.GaugeVec
-	gcl                *prometheus.GaugeVec
-	gpl                *prometheus.GaugeVec
-	rcl                *prometheus.GaugeVec
-	energy             *prometheus.GaugeVec
-	creeps             *prometheus.GaugeVec
-	structures         *prometheus.GaugeVec
-	storage            *prometheus.GaugeVec
-	terminal           *prometheus.GaugeVec
-	processingDuration prometheus.Histogram
-)
+// cacheTTLs holds the per-metric-family TTL the collector's caches are built
+// with, so one noisy endpoint (e.g. memory segments on a busy shard) can be
+// polled more or less eagerly than the others without affecting them.
+type cacheTTLs struct {
+	AuthMe       time.Duration
+	MarketOrders time.Duration
+	Stats        time.Duration
+}
 
-func setup() {
-	baseLabels := []string{"shard"}
+// NewScreepsCollector builds a collector scraping every account in accounts,
+// each with its own resolved token and shard list.
+func NewScreepsCollector(accounts []Account, ttl cacheTTLs) *ScreepsCollector {
+	baseLabels := []string{"account", "shard"}
 	typedLabels := append(baseLabels, "type")
 	roomLabels := append(baseLabels, "room")
 	roomTypedLabels := append(typedLabels, "room")
-	marketOrderLabels := append(roomTypedLabels, "order_type", "metric")
-
-	cpuShard = prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Name: prefix + "cpu_shard",
-		Help: "CPU allocated to each shard",
-	}, baseLabels)
-	resources = prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Name: prefix + "resources",
-		Help: "Resource amounts",
-	}, typedLabels)
-	marketOrders = prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Name: prefix + "market_orders",
-		Help: "Market orders",
-	}, marketOrderLabels)
-	tick = prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Name: prefix + "tick",
-		Help: "Current tick",
-	}, baseLabels)
-	ms = prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Name: prefix + "ms",
-		Help: "Current time",
-	}, baseLabels)
-	resetTick = prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Name: prefix + "global_reset_tick",
-		Help: "Last global reset tick",
-	}, baseLabels)
-	resetMs = prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Name: prefix + "global_reset_ms",
-		Help: "Last global reset time",
-	}, baseLabels)
-	cpu = prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Name: prefix + "cpu",
-		Help: "CPU statistics",
-	}, typedLabels)
-	gcl = prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Name: prefix + "gcl",
-		Help: "Global Control Level",
-	}, typedLabels)
-	gpl = prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Name: prefix + "gpl",
-		Help: "Global Power Level",
-	}, typedLabels)
-	rcl = prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Name: prefix + "rcl",
-		Help: "Room Control Level",
-	}, roomTypedLabels)
-	energy = prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Name: prefix + "energy",
-		Help: "Energy statistics",
-	}, roomTypedLabels)
-	creeps = prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Name: prefix + "creeps",
-		Help: "Creep counts",
-	}, roomLabels)
-	structures = prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Name: prefix + "structures",
-		Help: "Structure counts",
-	}, roomTypedLabels)
-	storage = prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Name: prefix + "storage",
-		Help: "Storage contents",
-	}, roomTypedLabels)
-	terminal = prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Name: prefix + "terminal",
-		Help: "Terminal contents",
-	}, roomTypedLabels)
-	processingDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
-		Name:    prefix + "stats_processing_time",
-		Help:    "Time it has taken to process stats",
-		Buckets: []float64{.001, .005, .01, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60},
-	})
+	marketOrderLabels := append(roomTypedLabels, "order_type", "metric", "order_id")
+
+	collector := &ScreepsCollector{
+		cpuShardDesc:     prometheus.NewDesc(prefix+"cpu_shard", "CPU allocated to each shard", baseLabels, nil),
+		resourcesDesc:    prometheus.NewDesc(prefix+"resources", "Resource amounts", typedLabels, nil),
+		marketOrdersDesc: prometheus.NewDesc(prefix+"market_orders", "Market orders", marketOrderLabels, nil),
+		tickDesc:         prometheus.NewDesc(prefix+"tick", "Current tick", baseLabels, nil),
+		msDesc:           prometheus.NewDesc(prefix+"ms", "Current time", baseLabels, nil),
+		resetTickDesc:    prometheus.NewDesc(prefix+"global_reset_tick", "Last global reset tick", baseLabels, nil),
+		resetMsDesc:      prometheus.NewDesc(prefix+"global_reset_ms", "Last global reset time", baseLabels, nil),
+		cpuDesc:          prometheus.NewDesc(prefix+"cpu", "CPU statistics", typedLabels, nil),
+		gclDesc:          prometheus.NewDesc(prefix+"gcl", "Global Control Level", typedLabels, nil),
+		gplDesc:          prometheus.NewDesc(prefix+"gpl", "Global Power Level", typedLabels, nil),
+		rclDesc:          prometheus.NewDesc(prefix+"rcl", "Room Control Level", roomTypedLabels, nil),
+		energyDesc:       prometheus.NewDesc(prefix+"energy", "Energy statistics", roomTypedLabels, nil),
+		creepsDesc:       prometheus.NewDesc(prefix+"creeps", "Creep counts", roomLabels, nil),
+		structuresDesc:   prometheus.NewDesc(prefix+"structures", "Structure counts", roomTypedLabels, nil),
+		storageDesc:      prometheus.NewDesc(prefix+"storage", "Storage contents", roomTypedLabels, nil),
+		terminalDesc:     prometheus.NewDesc(prefix+"terminal", "Terminal contents", roomTypedLabels, nil),
+
+		scrapeDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    prefix + "scrape_duration_seconds",
+			Help:    "Time spent talking to the Screeps API per endpoint during a scrape",
+			Buckets: []float64{.001, .005, .01, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60},
+		}, []string{"endpoint"}),
+		scrapeRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: prefix + "scrape_requests_total",
+			Help: "Screeps API requests made while serving a scrape, by endpoint and outcome",
+		}, []string{"endpoint", "outcome"}),
+		logStatCounter: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: prefix + "log_stat_total",
+			Help: "Count of structured stat lines seen on the console log subscription, by stat name",
+		}, []string{"account", "stat", "fields"}),
+	}
 
-	prometheus.MustRegister(cpuShard)
-	prometheus.MustRegister(resources)
-	prometheus.MustRegister(marketOrders)
-	prometheus.MustRegister(tick)
-	prometheus.MustRegister(ms)
-	prometheus.MustRegister(resetTick)
-	prometheus.MustRegister(resetMs)
-	prometheus.MustRegister(cpu)
-	prometheus.MustRegister(gcl)
-	prometheus.MustRegister(gpl)
-	prometheus.MustRegister(rcl)
-	prometheus.MustRegister(energy)
-	prometheus.MustRegister(creeps)
-	prometheus.MustRegister(structures)
-	prometheus.MustRegister(storage)
-	prometheus.MustRegister(terminal)
-	prometheus.MustRegister(processingDuration)
+	states := make([]*accountState, 0, len(accounts))
+	for _, account := range accounts {
+		states = append(states, &accountState{
+			name:     account.Name,
+			client:   screeps.NewClient(account.BaseURL, account.Token, screeps.WithInstrumentation(collector.recordScrape)),
+			shards:   account.Shards,
+			segments: account.Segments,
+			realtime: account.Realtime,
+
+			authMeCache:       newTTLCache(ttl.AuthMe),
+			marketOrdersCache: newTTLCache(ttl.MarketOrders),
+			statsCache:        newTTLCache(ttl.Stats),
+		})
+	}
+	collector.accounts = states
+
+	return collector
 }
 
-func getStatsFromAuthMe() (AuthMeResponse, error) {
-	req, err := http.NewRequest("GET", "https://screeps.com/api/auth/me", nil)
-	if err != nil {
-		return AuthMeResponse{}, err
-	}
-	req.Header.Set("X-Token", token)
-	resp, err := client.Do(req)
-	if err != nil {
-		return AuthMeResponse{}, err
-	}
-	defer resp.Body.Close()
-	var parsed AuthMeResponse
-	err = json.NewDecoder(resp.Body).Decode(&parsed)
-	if err != nil {
-		return AuthMeResponse{}, err
-	}
-	return parsed, nil
+func (c *ScreepsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.cpuShardDesc
+	ch <- c.resourcesDesc
+	ch <- c.marketOrdersDesc
+	ch <- c.tickDesc
+	ch <- c.msDesc
+	ch <- c.resetTickDesc
+	ch <- c.resetMsDesc
+	ch <- c.cpuDesc
+	ch <- c.gclDesc
+	ch <- c.gplDesc
+	ch <- c.rclDesc
+	ch <- c.energyDesc
+	ch <- c.creepsDesc
+	ch <- c.structuresDesc
+	ch <- c.storageDesc
+	ch <- c.terminalDesc
+	c.scrapeDuration.Describe(ch)
+	c.scrapeRequests.Describe(ch)
+	c.logStatCounter.Describe(ch)
 }
 
-func getMarketOrders() (MarketOrdersResponse, error) {
-	req, err := http.NewRequest("GET", "https://screeps.com/api/game/market/my-orders", nil)
+// recordScrape is passed to every account's screeps.Client as its
+// Instrumentation hook, feeding the collector's scrapeDuration/scrapeRequests
+// metrics from the client's own per-call timing instead of wrapping each
+// call site by hand.
+func (c *ScreepsCollector) recordScrape(endpoint string, duration time.Duration, err error) {
+	c.scrapeDuration.WithLabelValues(endpoint).Observe(duration.Seconds())
+	outcome := "success"
 	if err != nil {
-		return MarketOrdersResponse{}, err
+		outcome = "failure"
 	}
-	req.Header.Set("X-Token", token)
-	resp, err := client.Do(req)
-	if err != nil {
-		return MarketOrdersResponse{}, err
-	}
-	defer resp.Body.Close()
-	var parsed MarketOrdersResponse
-	err = json.NewDecoder(resp.Body).Decode(&parsed)
-	if err != nil {
-		return MarketOrdersResponse{}, err
-	}
-	return parsed, nil
+	c.scrapeRequests.WithLabelValues(endpoint, outcome).Inc()
 }
 
-func getStatsFromMemorySegment(shard string) (Stats, error) {
-	req, err := http.NewRequest("GET", "https://screeps.com/api/user/memory-segment?segment="+segment+"&shard="+shard, nil)
-	if err != nil {
-		return Stats{}, err
-	}
-	req.Header.Set("X-Token", token)
-	resp, err := client.Do(req)
-	if err != nil {
-		return Stats{}, err
-	}
-	defer resp.Body.Close()
-	var memoryResponse MemoryResponse
-	err = json.NewDecoder(resp.Body).Decode(&memoryResponse)
-	if err != nil {
-		return Stats{}, err
+func (c *ScreepsCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, account := range c.accounts {
+		c.collectAccount(ch, account)
 	}
-	var parsed Stats
-	err = json.Unmarshal([]byte(memoryResponse.Data), &parsed)
-	if err != nil {
-		return Stats{}, err
-	}
-	return parsed, nil
+	c.scrapeDuration.Collect(ch)
+	c.scrapeRequests.Collect(ch)
+	c.logStatCounter.Collect(ch)
 }
 
-func updateStats() {
-	start := time.Now()
+func (c *ScreepsCollector) collectAccount(ch chan<- prometheus.Metric, account *accountState) {
+	name := account.name
+	ctx := context.Background()
 
-	authMe, err := getStatsFromAuthMe()
+	authMeVal, err := account.authMeCache.Get(func() (interface{}, error) {
+		return account.client.AuthMe(ctx)
+	})
 	if err != nil {
 		fmt.Println(err)
 		return
 	}
-	orders, err := getMarketOrders()
+	authMe := authMeVal.(screeps.AuthMeResponse)
+
+	ordersVal, err := account.marketOrdersCache.Get(func() (interface{}, error) {
+		return account.client.MyOrders(ctx)
+	})
 	if err != nil {
 		fmt.Println(err)
 		return
 	}
-	var statsMap = make(map[string]Stats)
-	for _, shard := range shards {
-		stats, err := getStatsFromMemorySegment(shard)
-		if err != nil {
-			fmt.Println(err)
-			return
+	orders := ordersVal.(screeps.MarketOrdersResponse)
+
+	statsVal, err := account.statsCache.Get(func() (interface{}, error) {
+		statsMap := make(map[string]screeps.Stats)
+		for _, shard := range account.shards {
+			fetcher := account.fetcherForShard(shard)
+			stats, err := getStatsFromSegments(ctx, account.client, shard, account.segments, fetcher)
+			if err != nil {
+				return nil, err
+			}
+			statsMap[shard] = stats
 		}
-		statsMap[shard] = stats
+		return statsMap, nil
+	})
+	if err != nil {
+		fmt.Println(err)
+		return
 	}
-
-	cpuShard.Reset()
-	resources.Reset()
-	marketOrders.Reset()
-	rcl.Reset()
-	energy.Reset()
-	creeps.Reset()
-	structures.Reset()
-	storage.Reset()
-	terminal.Reset()
+	statsMap := statsVal.(map[string]screeps.Stats)
 
 	for shard, amount := range authMe.CPUShard {
-		cpuShard.With(prometheus.Labels{"shard": shard}).Set(amount)
+		ch <- prometheus.MustNewConstMetric(c.cpuShardDesc, prometheus.GaugeValue, amount, name, shard)
 	}
-	resources.With(prometheus.Labels{"shard": "intershard", "type": "money"}).Set(authMe.Money)
+	ch <- prometheus.MustNewConstMetric(c.resourcesDesc, prometheus.GaugeValue, authMe.Money, name, "intershard", "money")
 	for typ, amount := range authMe.Resources {
-		resources.With(prometheus.Labels{"shard": "intershard", "type": typ}).Set(amount)
+		ch <- prometheus.MustNewConstMetric(c.resourcesDesc, prometheus.GaugeValue, amount, name, "intershard", typ)
 	}
 
-	for shard, orders := range orders.Shards {
-		for _, order := range orders {
-			marketOrders.With(prometheus.Labels{"shard": shard, "room": order.RoomName, "type": order.ResourceType, "order_type": order.Type, "metric": "price"}).Set(order.Price)
-			marketOrders.With(prometheus.Labels{"shard": shard, "room": order.RoomName, "type": order.ResourceType, "order_type": order.Type, "metric": "amount"}).Set(order.Amount)
-			marketOrders.With(prometheus.Labels{"shard": shard, "room": order.RoomName, "type": order.ResourceType, "order_type": order.Type, "metric": "remainingAmount"}).Set(order.RemainingAmount)
-			marketOrders.With(prometheus.Labels{"shard": shard, "room": order.RoomName, "type": order.ResourceType, "order_type": order.Type, "metric": "totalAmount"}).Set(order.TotalAmount)
+	for shard, shardOrders := range orders.Shards {
+		for _, order := range shardOrders {
+			ch <- prometheus.MustNewConstMetric(c.marketOrdersDesc, prometheus.GaugeValue, order.Price, name, shard, order.ResourceType, order.RoomName, order.Type, "price", order.ID)
+			ch <- prometheus.MustNewConstMetric(c.marketOrdersDesc, prometheus.GaugeValue, order.Amount, name, shard, order.ResourceType, order.RoomName, order.Type, "amount", order.ID)
+			ch <- prometheus.MustNewConstMetric(c.marketOrdersDesc, prometheus.GaugeValue, order.RemainingAmount, name, shard, order.ResourceType, order.RoomName, order.Type, "remainingAmount", order.ID)
+			ch <- prometheus.MustNewConstMetric(c.marketOrdersDesc, prometheus.GaugeValue, order.TotalAmount, name, shard, order.ResourceType, order.RoomName, order.Type, "totalAmount", order.ID)
 		}
 	}
 
-	for _, shard := range shards {
-		var stats = statsMap[shard]
-		tick.With(prometheus.Labels{"shard": shard}).Set(stats.Tick)
-		ms.With(prometheus.Labels{"shard": shard}).Set(stats.Ms)
-		resetTick.With(prometheus.Labels{"shard": shard}).Set(stats.LastGlobalResetTick)
-		resetMs.With(prometheus.Labels{"shard": shard}).Set(stats.LastGlobalResetMs)
+	for _, shard := range account.shards {
+		stats, ok := statsMap[shard]
+		if !ok {
+			continue
+		}
+		stats = account.overlayLiveCPU(stats)
+		ch <- prometheus.MustNewConstMetric(c.tickDesc, prometheus.GaugeValue, stats.Tick, name, shard)
+		ch <- prometheus.MustNewConstMetric(c.msDesc, prometheus.GaugeValue, stats.Ms, name, shard)
+		ch <- prometheus.MustNewConstMetric(c.resetTickDesc, prometheus.GaugeValue, stats.LastGlobalResetTick, name, shard)
+		ch <- prometheus.MustNewConstMetric(c.resetMsDesc, prometheus.GaugeValue, stats.LastGlobalResetMs, name, shard)
 
-		cpu.With(prometheus.Labels{"shard": shard, "type": "used"}).Set(stats.CPU.Used)
-		cpu.With(prometheus.Labels{"shard": shard, "type": "limit"}).Set(stats.CPU.Limit)
-		cpu.With(prometheus.Labels{"shard": shard, "type": "bucket"}).Set(stats.CPU.Bucket)
+		ch <- prometheus.MustNewConstMetric(c.cpuDesc, prometheus.GaugeValue, stats.CPU.Used, name, shard, "used")
+		ch <- prometheus.MustNewConstMetric(c.cpuDesc, prometheus.GaugeValue, stats.CPU.Limit, name, shard, "limit")
+		ch <- prometheus.MustNewConstMetric(c.cpuDesc, prometheus.GaugeValue, stats.CPU.Bucket, name, shard, "bucket")
 
-		gcl.With(prometheus.Labels{"shard": shard, "type": "level"}).Set(stats.GCL.Level)
-		gcl.With(prometheus.Labels{"shard": shard, "type": "progress"}).Set(stats.GCL.Progress)
-		gcl.With(prometheus.Labels{"shard": shard, "type": "progressTotal"}).Set(stats.GCL.ProgressTotal)
+		ch <- prometheus.MustNewConstMetric(c.gclDesc, prometheus.GaugeValue, stats.GCL.Level, name, shard, "level")
+		ch <- prometheus.MustNewConstMetric(c.gclDesc, prometheus.GaugeValue, stats.GCL.Progress, name, shard, "progress")
+		ch <- prometheus.MustNewConstMetric(c.gclDesc, prometheus.GaugeValue, stats.GCL.ProgressTotal, name, shard, "progressTotal")
 
-		gpl.With(prometheus.Labels{"shard": shard, "type": "level"}).Set(stats.GPL.Level)
-		gpl.With(prometheus.Labels{"shard": shard, "type": "progress"}).Set(stats.GPL.Progress)
-		gpl.With(prometheus.Labels{"shard": shard, "type": "progressTotal"}).Set(stats.GPL.ProgressTotal)
+		ch <- prometheus.MustNewConstMetric(c.gplDesc, prometheus.GaugeValue, stats.GPL.Level, name, shard, "level")
+		ch <- prometheus.MustNewConstMetric(c.gplDesc, prometheus.GaugeValue, stats.GPL.Progress, name, shard, "progress")
+		ch <- prometheus.MustNewConstMetric(c.gplDesc, prometheus.GaugeValue, stats.GPL.ProgressTotal, name, shard, "progressTotal")
 
-		for name, room := range stats.Rooms {
-			rcl.With(prometheus.Labels{"shard": shard, "room": name, "type": "level"}).Set(room.RCL.Level)
-			rcl.With(prometheus.Labels{"shard": shard, "room": name, "type": "progress"}).Set(room.RCL.Progress)
-			rcl.With(prometheus.Labels{"shard": shard, "room": name, "type": "progressTotal"}).Set(room.RCL.ProgressTotal)
+		for roomName, room := range stats.Rooms {
+			ch <- prometheus.MustNewConstMetric(c.rclDesc, prometheus.GaugeValue, room.RCL.Level, name, shard, "level", roomName)
+			ch <- prometheus.MustNewConstMetric(c.rclDesc, prometheus.GaugeValue, room.RCL.Progress, name, shard, "progress", roomName)
+			ch <- prometheus.MustNewConstMetric(c.rclDesc, prometheus.GaugeValue, room.RCL.ProgressTotal, name, shard, "progressTotal", roomName)
 
-			energy.With(prometheus.Labels{"shard": shard, "room": name, "type": "available"}).Set(room.EnergyAvailable)
-			energy.With(prometheus.Labels{"shard": shard, "room": name, "type": "capacityAvailable"}).Set(room.EnergyCapacityAvailable)
+			ch <- prometheus.MustNewConstMetric(c.energyDesc, prometheus.GaugeValue, room.EnergyAvailable, name, shard, "available", roomName)
+			ch <- prometheus.MustNewConstMetric(c.energyDesc, prometheus.GaugeValue, room.EnergyCapacityAvailable, name, shard, "capacityAvailable", roomName)
 
-			creeps.With(prometheus.Labels{"shard": shard, "room": name}).Set(room.Creeps)
+			ch <- prometheus.MustNewConstMetric(c.creepsDesc, prometheus.GaugeValue, room.Creeps, name, shard, roomName)
 
 			for structureType, count := range room.Structures {
-				structures.With(prometheus.Labels{"shard": shard, "room": name, "type": structureType}).Set(count)
+				ch <- prometheus.MustNewConstMetric(c.structuresDesc, prometheus.GaugeValue, count, name, shard, structureType, roomName)
 			}
 			for resourceType, amount := range room.Storage {
-				storage.With(prometheus.Labels{"shard": shard, "room": name, "type": resourceType}).Set(amount)
+				ch <- prometheus.MustNewConstMetric(c.storageDesc, prometheus.GaugeValue, amount, name, shard, resourceType, roomName)
 			}
 			for resourceType, amount := range room.Terminal {
-				terminal.With(prometheus.Labels{"shard": shard, "room": name, "type": resourceType}).Set(amount)
+				ch <- prometheus.MustNewConstMetric(c.terminalDesc, prometheus.GaugeValue, amount, name, shard, resourceType, roomName)
 			}
 		}
 	}
-	processingDuration.Observe(time.Since(start).Seconds())
+}
+
+// IncrementLogStat increments the counter for a structured stat line parsed
+// off the realtime console subscription. The line's other fields are
+// untrusted and vary freely from one line to the next, so they're folded
+// into the single "fields" label instead of becoming label names themselves
+// - a dynamic label schema would let a console line register a conflicting
+// label set for an existing metric name and panic the whole exporter.
+func (c *ScreepsCollector) IncrementLogStat(account string, stat string, fields string) {
+	stat = nonAlphanumeric.ReplaceAllString(stat, "_")
+	c.logStatCounter.WithLabelValues(account, stat, fields).Inc()
+}
+
+// StartRealtime launches a websocket subscription goroutine for every
+// account configured for realtime updates.
+func (c *ScreepsCollector) StartRealtime() {
+	for _, account := range c.accounts {
+		if account.realtime {
+			go runRealtimeClient(c, account)
+		}
+	}
+}
+
+// newPusher builds a push.Pusher targeting pushURL, grouped under the given
+// job with the "instance" and "user" labels set, and with Basic Auth applied
+// if credentials were configured.
+func newPusher(pushURL, job, instance, user, pushUsername, pushPassword string, registry *prometheus.Registry) *push.Pusher {
+	pusher := push.New(pushURL, job).Gatherer(registry)
+	if instance != "" {
+		pusher = pusher.Grouping("instance", instance)
+	}
+	if user != "" {
+		pusher = pusher.Grouping("user", user)
+	}
+	if pushUsername != "" {
+		pusher = pusher.BasicAuth(pushUsername, pushPassword)
+	}
+	return pusher
+}
+
+// loadAccounts builds the account list either from a multi-account YAML
+// config (SCREEPS_CONFIG) or, for backwards compatibility, from the
+// single-account SCREEPS_* environment variables / CLI args. Private-server
+// accounts configured with a username/password instead of a token are
+// signed in here, once, up front.
+func loadAccounts(configPath string, shards []string, segments []int, token string, realtime bool) ([]Account, error) {
+	var accounts []Account
+	if configPath != "" {
+		config, err := LoadConfig(configPath)
+		if err != nil {
+			return nil, err
+		}
+		accounts = config.Accounts
+	} else {
+		accounts = []Account{{
+			Name:     "default",
+			BaseURL:  "https://screeps.com",
+			Token:    token,
+			Shards:   shards,
+			Segments: segments,
+			Realtime: realtime,
+		}}
+	}
+
+	for i, account := range accounts {
+		resolved, err := resolveToken(account)
+		if err != nil {
+			return nil, err
+		}
+		accounts[i].Token = resolved
+	}
+	return accounts, nil
 }
 
 func main() {
+	var (
+		shards       []string
+		segments     = []int{0}
+		token        = ""
+		configPath   = ""
+		realtime     = false
+		ttl          = 30 * time.Second
+		ttlAuthMe    time.Duration
+		ttlMarket    time.Duration
+		ttlStats     time.Duration
+		pushURL      = ""
+		pushInterval = time.Minute
+		pushJob      = "screeps_exporter"
+		pushInstance = ""
+		pushUser     = ""
+		pushUsername = ""
+		pushPassword = ""
+	)
+
 	for _, e := range os.Environ() {
 		split := strings.SplitN(e, "=", 2)
 		switch split[0] {
 		case "SCREEPS_SHARDS":
 			shards = strings.Split(split[1], ",")
-		case "SCREEPS_SEGMENT":
-			segment = split[1]
+		case "SCREEPS_SEGMENT", "SCREEPS_SEGMENTS":
+			if parsed, err := ParseSegments(split[1]); err == nil {
+				segments = parsed
+			}
 		case "SCREEPS_TOKEN":
 			token = split[1]
+		case "SCREEPS_CONFIG":
+			configPath = split[1]
+		case "SCREEPS_REALTIME":
+			if parsed, err := strconv.ParseBool(split[1]); err == nil {
+				realtime = parsed
+			}
+		case "SCREEPS_CACHE_TTL":
+			if parsed, err := time.ParseDuration(split[1]); err == nil {
+				ttl = parsed
+			}
+		case "SCREEPS_CACHE_TTL_AUTH_ME":
+			if parsed, err := time.ParseDuration(split[1]); err == nil {
+				ttlAuthMe = parsed
+			}
+		case "SCREEPS_CACHE_TTL_MARKET_ORDERS":
+			if parsed, err := time.ParseDuration(split[1]); err == nil {
+				ttlMarket = parsed
+			}
+		case "SCREEPS_CACHE_TTL_STATS":
+			if parsed, err := time.ParseDuration(split[1]); err == nil {
+				ttlStats = parsed
+			}
+		case "SCREEPS_PUSH_URL":
+			pushURL = split[1]
+		case "SCREEPS_PUSH_INTERVAL":
+			if parsed, err := time.ParseDuration(split[1]); err == nil {
+				pushInterval = parsed
+			}
+		case "SCREEPS_PUSH_JOB":
+			pushJob = split[1]
+		case "SCREEPS_PUSH_INSTANCE":
+			pushInstance = split[1]
+		case "SCREEPS_PUSH_USER":
+			pushUser = split[1]
+		case "SCREEPS_PUSH_USERNAME":
+			pushUsername = split[1]
+		case "SCREEPS_PUSH_PASSWORD":
+			pushPassword = split[1]
 		}
 	}
 
@@ -371,18 +544,57 @@ func main() {
 		token = os.Args[2]
 	}
 
-	if len(shards) < 1 || token == "" {
+	if configPath == "" && (len(shards) < 1 || token == "") {
 		log.Fatal("invalid config")
 	}
 
-	setup()
-	go func() {
+	accounts, err := loadAccounts(configPath, shards, segments, token, realtime)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	resolveTTL := func(override time.Duration) time.Duration {
+		if override != 0 {
+			return override
+		}
+		return ttl
+	}
+
+	registry := prometheus.NewRegistry()
+	collector := NewScreepsCollector(accounts, cacheTTLs{
+		AuthMe:       resolveTTL(ttlAuthMe),
+		MarketOrders: resolveTTL(ttlMarket),
+		Stats:        resolveTTL(ttlStats),
+	})
+	registry.MustRegister(collector)
+	collector.StartRealtime()
+
+	if pushURL != "" {
+		pusher := newPusher(pushURL, pushJob, pushInstance, pushUser, pushUsername, pushPassword, registry)
+
+		stop := make(chan os.Signal, 1)
+		signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+
+		ticker := time.NewTicker(pushInterval)
+		defer ticker.Stop()
 		for {
-			updateStats()
-			time.Sleep(time.Minute)
+			select {
+			case <-ticker.C:
+				if err := pusher.Push(); err != nil {
+					fmt.Println(err)
+				}
+			case <-stop:
+				if err := pusher.Push(); err != nil {
+					fmt.Println(err)
+				}
+				if err := pusher.Delete(); err != nil {
+					fmt.Println(err)
+				}
+				return
+			}
 		}
-	}()
+	}
 
-	http.Handle("/metrics", promhttp.Handler())
+	http.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
 	http.ListenAndServe(":8080", nil)
 }
@@ -0,0 +1,269 @@
+// Package screeps is a typed, instrumentable client for the Screeps HTTP
+// API, shared by the exporter and usable independently by other Go tooling
+// in the Screeps ecosystem.
+package screeps
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// defaultMaxRetries is how many times a request is retried on a 5xx response
+// or an exhausted rate limit before giving up.
+const defaultMaxRetries = 3
+
+// defaultRetryBackoff is the base backoff between retries when the server
+// doesn't tell us how long to wait via X-Ratelimit-Reset.
+const defaultRetryBackoff = time.Second
+
+// Instrumentation is called once per Client call (after retries are
+// exhausted or a response is decoded), so callers can record round-trip
+// histograms or success/failure counters per endpoint.
+type Instrumentation func(endpoint string, duration time.Duration, err error)
+
+// Client talks to a single Screeps server (official or private) on behalf
+// of one account.
+type Client struct {
+	baseURL    string
+	token      string
+	httpClient *http.Client
+	maxRetries int
+	instrument Instrumentation
+}
+
+// Option configures a Client constructed with NewClient.
+type Option func(*Client)
+
+// WithHTTPClient overrides the *http.Client used for requests.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) { c.httpClient = httpClient }
+}
+
+// WithMaxRetries overrides how many times a failed request is retried.
+func WithMaxRetries(n int) Option {
+	return func(c *Client) { c.maxRetries = n }
+}
+
+// WithInstrumentation registers a callback invoked after every Client call.
+func WithInstrumentation(instrument Instrumentation) Option {
+	return func(c *Client) { c.instrument = instrument }
+}
+
+// NewClient builds a Client for the Screeps server at baseURL (e.g.
+// "https://screeps.com"), authenticated with token.
+func NewClient(baseURL string, token string, opts ...Option) *Client {
+	c := &Client{
+		baseURL:    baseURL,
+		token:      token,
+		httpClient: &http.Client{},
+		maxRetries: defaultMaxRetries,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// BaseURL returns the server base URL the client was constructed with.
+func (c *Client) BaseURL() string { return c.baseURL }
+
+// Token returns the API token the client was constructed with.
+func (c *Client) Token() string { return c.token }
+
+// AuthMe calls GET /api/auth/me.
+func (c *Client) AuthMe(ctx context.Context) (AuthMeResponse, error) {
+	var out AuthMeResponse
+	err := c.get(ctx, "auth_me", "/api/auth/me", &out)
+	return out, err
+}
+
+// MyOrders calls GET /api/game/market/my-orders.
+func (c *Client) MyOrders(ctx context.Context) (MarketOrdersResponse, error) {
+	var out MarketOrdersResponse
+	err := c.get(ctx, "my_orders", "/api/game/market/my-orders", &out)
+	return out, err
+}
+
+// MemorySegment fetches and decodes a single memory segment into Stats.
+func (c *Client) MemorySegment(ctx context.Context, shard string, segment int) (Stats, error) {
+	var mem memoryResponse
+	path := fmt.Sprintf("/api/user/memory-segment?segment=%d&shard=%s", segment, shard)
+	if err := c.get(ctx, "memory_segment", path, &mem); err != nil {
+		return Stats{}, err
+	}
+	var stats Stats
+	if err := json.Unmarshal([]byte(mem.Data), &stats); err != nil {
+		return Stats{}, err
+	}
+	return stats, nil
+}
+
+// MarketHistory calls GET /api/game/market/history for a resource type, or
+// every resource's history if resourceType is empty.
+func (c *Client) MarketHistory(ctx context.Context, resourceType string) (MarketHistoryResponse, error) {
+	path := "/api/game/market/history"
+	if resourceType != "" {
+		path += "?resourceType=" + resourceType
+	}
+	var out MarketHistoryResponse
+	err := c.get(ctx, "market_history", path, &out)
+	return out, err
+}
+
+// MarketStats calls GET /api/game/market/stats for a resource type.
+func (c *Client) MarketStats(ctx context.Context, resourceType string) (MarketStatsResponse, error) {
+	path := "/api/game/market/stats?resourceType=" + resourceType
+	var out MarketStatsResponse
+	err := c.get(ctx, "market_stats", path, &out)
+	return out, err
+}
+
+// UserOverview calls GET /api/user/overview for the given interval (in
+// ticks, e.g. "8" or "180").
+func (c *Client) UserOverview(ctx context.Context, interval string) (UserOverviewResponse, error) {
+	path := "/api/user/overview?interval=" + interval
+	var out UserOverviewResponse
+	err := c.get(ctx, "user_overview", path, &out)
+	return out, err
+}
+
+// RoomObjects calls GET /api/game/room-objects for a single room.
+func (c *Client) RoomObjects(ctx context.Context, shard string, room string) (RoomObjectsResponse, error) {
+	path := fmt.Sprintf("/api/game/room-objects?shard=%s&room=%s", shard, room)
+	var out RoomObjectsResponse
+	err := c.get(ctx, "room_objects", path, &out)
+	return out, err
+}
+
+// Leaderboard calls GET /api/leaderboard/list for a season (e.g. "2024").
+func (c *Client) Leaderboard(ctx context.Context, season string) (LeaderboardResponse, error) {
+	path := "/api/leaderboard/list?season=" + season
+	var out LeaderboardResponse
+	err := c.get(ctx, "leaderboard", path, &out)
+	return out, err
+}
+
+// SignIn exchanges a private server username/password for an API token
+// using HTTP Basic Auth against /api/auth/signin, since private servers have
+// no concept of the pre-issued tokens official servers use.
+func (c *Client) SignIn(ctx context.Context, username string, password string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", c.baseURL+"/api/auth/signin", nil)
+	if err != nil {
+		return "", err
+	}
+	req.SetBasicAuth(username, password)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var parsed struct {
+		Ok    float64 `json:"ok"`
+		Token string  `json:"token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&parsed); err != nil {
+		return "", err
+	}
+	if parsed.Token == "" {
+		return "", fmt.Errorf("signin to %s did not return a token", c.baseURL)
+	}
+	return parsed.Token, nil
+}
+
+// get performs a GET request against path, decoding the JSON response into
+// out, retrying on 5xx responses and 429s and reporting the outcome to the
+// configured Instrumentation. Any other 4xx is terminal: retrying a bad
+// token or a wrong path can't succeed, so it's returned immediately instead
+// of blocking the caller through a full backoff schedule.
+func (c *Client) get(ctx context.Context, endpoint string, path string, out interface{}) error {
+	start := time.Now()
+	err := c.doWithRetry(ctx, path, out)
+	if c.instrument != nil {
+		c.instrument(endpoint, time.Since(start), err)
+	}
+	return err
+}
+
+func (c *Client) doWithRetry(ctx context.Context, path string, out interface{}) error {
+	backoff := defaultRetryBackoff
+	var lastErr error
+	for attempt := 0; attempt <= c.maxRetries; attempt++ {
+		wait, terminal, err := c.doOnce(ctx, path, out)
+		if err == nil {
+			return nil
+		}
+		lastErr = err
+		if terminal {
+			return err
+		}
+		if wait == 0 {
+			wait = backoff
+			backoff *= 2
+		}
+		if attempt == c.maxRetries {
+			break
+		}
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return lastErr
+}
+
+// doOnce performs a single request attempt. It returns a non-zero retryAfter
+// when the caller should back off for that long before retrying (derived
+// from the Screeps rate-limit headers on a 429), terminal true for a failure
+// that retrying cannot fix, and a non-nil error for any failed attempt.
+func (c *Client) doOnce(ctx context.Context, path string, out interface{}) (retryAfter time.Duration, terminal bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.baseURL+path, nil)
+	if err != nil {
+		return 0, true, err
+	}
+	req.Header.Set("X-Token", c.token)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return 0, false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return rateLimitResetDuration(resp.Header.Get("X-Ratelimit-Reset")), false, fmt.Errorf("%s: rate limited", path)
+	}
+	if resp.StatusCode >= 500 {
+		return 0, false, fmt.Errorf("%s: server error %d", path, resp.StatusCode)
+	}
+	if resp.StatusCode >= 400 {
+		return 0, true, fmt.Errorf("%s: unexpected status %d", path, resp.StatusCode)
+	}
+
+	if out == nil {
+		return 0, false, nil
+	}
+	return 0, false, json.NewDecoder(resp.Body).Decode(out)
+}
+
+// rateLimitResetDuration interprets the X-Ratelimit-Reset header (a Unix
+// timestamp in seconds) as a wait duration from now.
+func rateLimitResetDuration(reset string) time.Duration {
+	if reset == "" {
+		return defaultRetryBackoff
+	}
+	resetAt, err := strconv.ParseInt(reset, 10, 64)
+	if err != nil {
+		return defaultRetryBackoff
+	}
+	wait := time.Until(time.Unix(resetAt, 0))
+	if wait <= 0 {
+		return defaultRetryBackoff
+	}
+	return wait
+}
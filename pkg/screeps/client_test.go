@@ -0,0 +1,99 @@
+package screeps
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAuthMe(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/auth/me" {
+			t.Fatalf("unexpected path %q", r.URL.Path)
+		}
+		if token := r.Header.Get("X-Token"); token != "test-token" {
+			t.Fatalf("unexpected token %q", token)
+		}
+		w.Write([]byte(`{"_id":"abc123","money":42,"cpuShard":{"shard0":10}}`))
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, "test-token")
+	authMe, err := client.AuthMe(context.Background())
+	if err != nil {
+		t.Fatalf("AuthMe: %v", err)
+	}
+	if authMe.ID != "abc123" || authMe.Money != 42 || authMe.CPUShard["shard0"] != 10 {
+		t.Fatalf("unexpected AuthMeResponse: %+v", authMe)
+	}
+}
+
+func TestMemorySegment(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"ok":1,"data":"{\"tick\":123,\"rooms\":{\"W1N1\":{\"creeps\":5}}}"}`))
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, "test-token")
+	stats, err := client.MemorySegment(context.Background(), "shard0", 0)
+	if err != nil {
+		t.Fatalf("MemorySegment: %v", err)
+	}
+	if stats.Tick != 123 || stats.Rooms["W1N1"].Creeps != 5 {
+		t.Fatalf("unexpected Stats: %+v", stats)
+	}
+}
+
+func TestRetriesOnServerError(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts < 3 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Write([]byte(`{"_id":"abc123"}`))
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, "test-token", WithMaxRetries(3))
+	start := time.Now()
+	authMe, err := client.AuthMe(context.Background())
+	if err != nil {
+		t.Fatalf("AuthMe: %v", err)
+	}
+	if authMe.ID != "abc123" {
+		t.Fatalf("unexpected AuthMeResponse: %+v", authMe)
+	}
+	if attempts != 3 {
+		t.Fatalf("expected 3 attempts, got %d", attempts)
+	}
+	if time.Since(start) <= 0 {
+		t.Fatalf("expected retries to take nonzero time")
+	}
+}
+
+func TestInstrumentationHook(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"_id":"abc123"}`))
+	}))
+	defer server.Close()
+
+	var gotEndpoint string
+	var gotErr error
+	client := NewClient(server.URL, "test-token", WithInstrumentation(func(endpoint string, duration time.Duration, err error) {
+		gotEndpoint = endpoint
+		gotErr = err
+	}))
+	if _, err := client.AuthMe(context.Background()); err != nil {
+		t.Fatalf("AuthMe: %v", err)
+	}
+	if gotEndpoint != "auth_me" {
+		t.Fatalf("expected instrumentation for auth_me, got %q", gotEndpoint)
+	}
+	if gotErr != nil {
+		t.Fatalf("unexpected instrumentation error: %v", gotErr)
+	}
+}
@@ -0,0 +1,120 @@
+package screeps
+
+// AuthMeResponse is the response of GET /api/auth/me.
+type AuthMeResponse struct {
+	ID        string             `json:"_id"`
+	Money     float64            `json:"money"`
+	CPUShard  map[string]float64 `json:"cpuShard"`
+	Resources map[string]float64 `json:"resources"`
+}
+
+// MarketOrder is a single order as returned by GET /api/game/market/my-orders.
+type MarketOrder struct {
+	ID              string  `json:"_id"`
+	Active          bool    `json:"active"`
+	Type            string  `json:"type"`
+	Amount          float64 `json:"amount"`
+	RemainingAmount float64 `json:"remainingAmount"`
+	ResourceType    string  `json:"resourceType"`
+	Price           float64 `json:"price"`
+	TotalAmount     float64 `json:"totalAmount"`
+	RoomName        string  `json:"roomName"`
+}
+
+// MarketOrdersResponse is the response of GET /api/game/market/my-orders.
+type MarketOrdersResponse struct {
+	Shards map[string][]MarketOrder `json:"shards"`
+}
+
+// memoryResponse is the raw response of GET /api/user/memory-segment, before
+// its Data field (a JSON-encoded string) is itself decoded into a Stats.
+type memoryResponse struct {
+	Ok   float64 `json:"ok"`
+	Data string  `json:"data"`
+}
+
+// Cpu holds a shard's CPU usage as published in a Stats memory segment.
+type Cpu struct {
+	Used   float64 `json:"used"`
+	Limit  float64 `json:"limit"`
+	Bucket float64 `json:"bucket"`
+}
+
+// Progress describes a GCL/GPL/RCL progress bar.
+type Progress struct {
+	Level         float64 `json:"level"`
+	Progress      float64 `json:"progress"`
+	ProgressTotal float64 `json:"progressTotal"`
+}
+
+// Room is a single room's worth of stats as published in a memory segment.
+type Room struct {
+	RCL                     Progress           `json:"rcl"`
+	Structures              map[string]float64 `json:"structures"`
+	Creeps                  float64            `json:"creeps"`
+	EnergyAvailable         float64            `json:"energyAvailable"`
+	EnergyCapacityAvailable float64            `json:"energyCapacityAvailable"`
+	Storage                 map[string]float64 `json:"storage"`
+	Terminal                map[string]float64 `json:"terminal"`
+}
+
+// Stats is the decoded contents of a player's stats memory segment.
+type Stats struct {
+	Tick                float64         `json:"tick"`
+	Ms                  float64         `json:"ms"`
+	LastGlobalResetTick float64         `json:"lastGlobalResetTick"`
+	LastGlobalResetMs   float64         `json:"lastGlobalResetMs"`
+	CPU                 Cpu             `json:"cpu"`
+	GCL                 Progress        `json:"gcl"`
+	GPL                 Progress        `json:"gpl"`
+	Rooms               map[string]Room `json:"rooms"`
+}
+
+// MarketHistoryEntry is a single day's market summary for a resource, as
+// returned by GET /api/game/market/history.
+type MarketHistoryEntry struct {
+	ResourceType string  `json:"resourceType"`
+	Date         string  `json:"date"`
+	Transactions float64 `json:"transactions"`
+	Volume       float64 `json:"volume"`
+	AvgPrice     float64 `json:"avgPrice"`
+	StddevPrice  float64 `json:"stddevPrice"`
+}
+
+// MarketHistoryResponse is the response of GET /api/game/market/history.
+type MarketHistoryResponse struct {
+	Ok      float64              `json:"ok"`
+	History []MarketHistoryEntry `json:"history"`
+}
+
+// MarketStatsResponse is the response of GET /api/game/market/stats.
+type MarketStatsResponse struct {
+	Ok    float64                `json:"ok"`
+	Stats map[string]interface{} `json:"stats"`
+}
+
+// UserOverviewResponse is the response of GET /api/user/overview.
+type UserOverviewResponse struct {
+	Ok    float64                       `json:"ok"`
+	Total map[string]float64            `json:"total"`
+	Rooms map[string]map[string]float64 `json:"rooms"`
+}
+
+// RoomObjectsResponse is the response of GET /api/game/room-objects.
+type RoomObjectsResponse struct {
+	Ok      float64                  `json:"ok"`
+	Objects []map[string]interface{} `json:"objects"`
+}
+
+// LeaderboardEntry is a single row of GET /api/leaderboard/list.
+type LeaderboardEntry struct {
+	Rank   float64 `json:"rank"`
+	UserID string  `json:"_id"`
+	Score  float64 `json:"score"`
+}
+
+// LeaderboardResponse is the response of GET /api/leaderboard/list.
+type LeaderboardResponse struct {
+	Ok   float64            `json:"ok"`
+	List []LeaderboardEntry `json:"list"`
+}
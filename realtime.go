@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/Scrin/screeps-exporter/pkg/screeps"
+)
+
+// realtimeReconnectMinBackoff/MaxBackoff bound the exponential backoff used
+// between websocket reconnect attempts.
+const (
+	realtimeReconnectMinBackoff = time.Second
+	realtimeReconnectMaxBackoff = time.Minute
+)
+
+// cpuChannelMessage is the payload of a "user:<id>/cpu" subscription push.
+type cpuChannelMessage struct {
+	CPU    float64 `json:"cpu"`
+	Memory float64 `json:"memory"`
+}
+
+// consoleChannelMessage is the payload of a "user:<id>/console" subscription
+// push.
+type consoleChannelMessage struct {
+	Messages struct {
+		Log []string `json:"log"`
+	} `json:"messages"`
+	Shard string `json:"shard"`
+}
+
+// runRealtimeClient subscribes to an account's console and cpu channels over
+// the Screeps websocket API and keeps the connection alive across drops,
+// reconnecting with exponential backoff. It never returns; call it with go.
+func runRealtimeClient(collector *ScreepsCollector, account *accountState) {
+	backoff := realtimeReconnectMinBackoff
+	for {
+		connectedAt := time.Now()
+		if err := connectRealtimeOnce(collector, account); err != nil {
+			fmt.Printf("realtime[%s]: %v\n", account.name, err)
+		}
+		if time.Since(connectedAt) > realtimeReconnectMaxBackoff {
+			backoff = realtimeReconnectMinBackoff
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > realtimeReconnectMaxBackoff {
+			backoff = realtimeReconnectMaxBackoff
+		}
+	}
+}
+
+func wsURL(baseURL string) string {
+	url := strings.Replace(baseURL, "https://", "wss://", 1)
+	url = strings.Replace(url, "http://", "ws://", 1)
+	return url + "/socket/websocket"
+}
+
+// connectRealtimeOnce dials the websocket once, authenticates, subscribes,
+// and processes messages until the connection drops or an unrecoverable
+// error occurs.
+func connectRealtimeOnce(collector *ScreepsCollector, account *accountState) error {
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL(account.client.BaseURL()), nil)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if err := conn.WriteMessage(websocket.TextMessage, []byte("auth "+account.client.Token())); err != nil {
+		return err
+	}
+
+	authMe, err := account.client.AuthMe(context.Background())
+	if err != nil {
+		return err
+	}
+	if authMe.ID == "" {
+		return fmt.Errorf("could not resolve user id for realtime subscriptions")
+	}
+
+	channels := []string{
+		"user:" + authMe.ID + "/cpu",
+		"user:" + authMe.ID + "/console",
+	}
+	for _, channel := range channels {
+		if err := conn.WriteMessage(websocket.TextMessage, []byte("subscribe "+channel)); err != nil {
+			return err
+		}
+	}
+
+	for {
+		_, raw, err := conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+		handleRealtimeMessage(collector, account, raw)
+	}
+}
+
+// handleRealtimeMessage decodes a single websocket frame (inflating it first
+// if it carries the "gz:" compressed-frame prefix) and dispatches it based on
+// its subscription channel.
+func handleRealtimeMessage(collector *ScreepsCollector, account *accountState, raw []byte) {
+	msg := string(raw)
+	if strings.HasPrefix(msg, "gz:") {
+		inflated, err := inflateGzipFrame(msg[len("gz:"):])
+		if err != nil {
+			fmt.Printf("realtime[%s]: %v\n", account.name, err)
+			return
+		}
+		msg = inflated
+	}
+
+	var frame []json.RawMessage
+	if err := json.Unmarshal([]byte(msg), &frame); err != nil || len(frame) != 2 {
+		// Not a channel push (e.g. the "auth ok"/time_sync control messages).
+		return
+	}
+
+	var channel string
+	if err := json.Unmarshal(frame[0], &channel); err != nil {
+		return
+	}
+
+	switch {
+	case strings.HasSuffix(channel, "/cpu"):
+		var payload cpuChannelMessage
+		if err := json.Unmarshal(frame[1], &payload); err == nil {
+			account.recordLiveCPU(screeps.Cpu{Used: payload.CPU})
+		}
+	case strings.HasSuffix(channel, "/console"):
+		var payload consoleChannelMessage
+		if err := json.Unmarshal(frame[1], &payload); err == nil {
+			for _, line := range payload.Messages.Log {
+				handleConsoleLogLine(collector, account, line)
+			}
+		}
+	}
+}
+
+// handleConsoleLogLine looks for structured JSON log lines of the form
+// {"stat": "creep_died", "role": "miner", "room": "W1N1"} and turns them into
+// an increment of the log-stat counter, folding whatever other string fields
+// the line carried into a single "key=value,..." label value.
+func handleConsoleLogLine(collector *ScreepsCollector, account *accountState, line string) {
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(line), &fields); err != nil {
+		return
+	}
+	statValue, ok := fields["stat"]
+	if !ok {
+		return
+	}
+	stat, ok := statValue.(string)
+	if !ok || stat == "" {
+		return
+	}
+
+	var extra []string
+	for k, v := range fields {
+		if k == "stat" {
+			continue
+		}
+		if s, ok := v.(string); ok {
+			extra = append(extra, k+"="+s)
+		}
+	}
+	sort.Strings(extra)
+
+	collector.IncrementLogStat(account.name, stat, strings.Join(extra, ","))
+}
+
+func inflateGzipFrame(encoded string) (string, error) {
+	compressed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", err
+	}
+	reader, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+	inflated, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return "", err
+	}
+	return string(inflated), nil
+}
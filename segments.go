@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"sync"
+
+	"github.com/Scrin/screeps-exporter/pkg/screeps"
+)
+
+// maxSegmentsPerTick is the number of memory segments the Screeps server
+// lets a single tick request; segmentFetcher spreads larger segment lists
+// across multiple scrapes instead of requesting them all at once.
+const maxSegmentsPerTick = 10
+
+// segmentBatchConcurrency bounds how many segment requests a single batch
+// fires off in parallel.
+const segmentBatchConcurrency = 5
+
+// segmentFetcher tracks, per shard, which segments have been fetched so far
+// and the last decoded Stats for each, so that a segment list larger than
+// maxSegmentsPerTick can be polled a batch at a time across successive
+// scrapes while still reporting a merged view of every segment's last known
+// contents.
+type segmentFetcher struct {
+	mu     sync.Mutex
+	offset int
+	cache  map[int]screeps.Stats
+}
+
+// nextBatch returns the next up-to-maxSegmentsPerTick segments to actually
+// fetch this round, advancing the round-robin offset so later calls cover
+// the remaining segments.
+func (f *segmentFetcher) nextBatch(segments []int) []int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(segments) <= maxSegmentsPerTick {
+		return segments
+	}
+	batch := make([]int, 0, maxSegmentsPerTick)
+	for i := 0; i < maxSegmentsPerTick; i++ {
+		batch = append(batch, segments[(f.offset+i)%len(segments)])
+	}
+	f.offset = (f.offset + maxSegmentsPerTick) % len(segments)
+	return batch
+}
+
+// getStatsFromSegments fetches the given memory segments for shard (in
+// parallel, respecting the server's per-tick segment limit by batching
+// across calls) and deep-merges the decoded Stats structs into a single
+// result, falling back to the last successfully fetched value for segments
+// not included in this round's batch.
+func getStatsFromSegments(ctx context.Context, client *screeps.Client, shard string, segments []int, fetcher *segmentFetcher) (screeps.Stats, error) {
+	batch := fetcher.nextBatch(segments)
+
+	type result struct {
+		segment int
+		stats   screeps.Stats
+		err     error
+	}
+	results := make(chan result, len(batch))
+	sem := make(chan struct{}, segmentBatchConcurrency)
+	var wg sync.WaitGroup
+	for _, segment := range batch {
+		wg.Add(1)
+		go func(segment int) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			stats, err := client.MemorySegment(ctx, shard, segment)
+			results <- result{segment: segment, stats: stats, err: err}
+		}(segment)
+	}
+	wg.Wait()
+	close(results)
+
+	fetcher.mu.Lock()
+	defer fetcher.mu.Unlock()
+	if fetcher.cache == nil {
+		fetcher.cache = make(map[int]screeps.Stats)
+	}
+	for r := range results {
+		if r.err != nil {
+			return screeps.Stats{}, r.err
+		}
+		fetcher.cache[r.segment] = r.stats
+	}
+
+	merged := screeps.Stats{Rooms: make(map[string]screeps.Room)}
+	for _, segment := range segments {
+		if stats, ok := fetcher.cache[segment]; ok {
+			mergeStats(&merged, stats)
+		}
+	}
+	return merged, nil
+}
+
+// mergeStats folds src into dst: scalar fields are overwritten whenever src
+// carries a non-zero value, and dst.Rooms is deep-merged via mergeRoom.
+func mergeStats(dst *screeps.Stats, src screeps.Stats) {
+	if src.Tick != 0 {
+		dst.Tick = src.Tick
+	}
+	if src.Ms != 0 {
+		dst.Ms = src.Ms
+	}
+	if src.LastGlobalResetTick != 0 {
+		dst.LastGlobalResetTick = src.LastGlobalResetTick
+	}
+	if src.LastGlobalResetMs != 0 {
+		dst.LastGlobalResetMs = src.LastGlobalResetMs
+	}
+	if src.CPU != (screeps.Cpu{}) {
+		dst.CPU = src.CPU
+	}
+	if src.GCL != (screeps.Progress{}) {
+		dst.GCL = src.GCL
+	}
+	if src.GPL != (screeps.Progress{}) {
+		dst.GPL = src.GPL
+	}
+	if dst.Rooms == nil {
+		dst.Rooms = make(map[string]screeps.Room)
+	}
+	for name, room := range src.Rooms {
+		if existing, ok := dst.Rooms[name]; ok {
+			dst.Rooms[name] = mergeRoom(existing, room)
+		} else {
+			dst.Rooms[name] = room
+		}
+	}
+}
+
+// mergeRoom combines two Room snapshots of the same room read from different
+// segments: structure/storage/terminal amounts are summed, since a player
+// splitting stats across segments typically shards them by resource rather
+// than duplicating the same numbers.
+func mergeRoom(a, b screeps.Room) screeps.Room {
+	if b.RCL != (screeps.Progress{}) {
+		a.RCL = b.RCL
+	}
+	if b.Creeps != 0 {
+		a.Creeps = b.Creeps
+	}
+	if b.EnergyAvailable != 0 {
+		a.EnergyAvailable = b.EnergyAvailable
+	}
+	if b.EnergyCapacityAvailable != 0 {
+		a.EnergyCapacityAvailable = b.EnergyCapacityAvailable
+	}
+	a.Structures = sumFloatMaps(a.Structures, b.Structures)
+	a.Storage = sumFloatMaps(a.Storage, b.Storage)
+	a.Terminal = sumFloatMaps(a.Terminal, b.Terminal)
+	return a
+}
+
+// sumFloatMaps returns a new map holding the per-key sums of a and b (or a
+// itself if b is empty). Neither input is modified, so the maps of cached
+// segment Stats are never altered by a merge.
+func sumFloatMaps(a, b map[string]float64) map[string]float64 {
+	if len(b) == 0 {
+		return a
+	}
+	sum := make(map[string]float64, len(a)+len(b))
+	for k, v := range a {
+		sum[k] = v
+	}
+	for k, v := range b {
+		sum[k] += v
+	}
+	return sum
+}